@@ -0,0 +1,88 @@
+package patch
+
+import (
+	"container/list"
+	"testing"
+)
+
+func newTestPatchForAnalysis(bodyLines []*PatchLine) *Patch {
+	return &Patch{
+		hunks: []hunk{
+			{bodyLines: bodyLines},
+		},
+	}
+}
+
+func TestReconstructCodeForAnalysis_StripsMarkersAndJoinsLines(t *testing.T) {
+	patch := newTestPatchForAnalysis([]*PatchLine{
+		{Content: " package main"},
+		{Content: "+func main() {"},
+		{Content: "-\tfmt.Println(\"old\")"},
+		{Content: "+\tfmt.Println(\"new\")"},
+		{Content: "+}"},
+	})
+
+	got := reconstructCodeForAnalysis(patch, 10)
+	want := "package main\nfunc main() {\n\tfmt.Println(\"old\")\n\tfmt.Println(\"new\")\n}\n"
+	if got != want {
+		t.Errorf("reconstructCodeForAnalysis() = %q, want %q", got, want)
+	}
+}
+
+func TestReconstructCodeForAnalysis_StopsAtMaxLines(t *testing.T) {
+	patch := newTestPatchForAnalysis([]*PatchLine{
+		{Content: "+one"},
+		{Content: "+two"},
+		{Content: "+three"},
+	})
+
+	got := reconstructCodeForAnalysis(patch, 2)
+	want := "one\ntwo\n"
+	if got != want {
+		t.Errorf("reconstructCodeForAnalysis() with maxLines=2 = %q, want %q", got, want)
+	}
+}
+
+func TestAnalyseLexerForPatch_ResolvesLexerFromContent(t *testing.T) {
+	patch := newTestPatchForAnalysis([]*PatchLine{
+		{Content: " package main"},
+		{Content: "+"},
+		{Content: "+func main() {"},
+		{Content: "+\tfmt.Println(\"hello, world\")"},
+		{Content: "+}"},
+	})
+
+	lexer := analyseLexerForPatch(patch)
+	if lexer == nil {
+		t.Fatalf("expected content analysis to resolve a lexer for Go-like content")
+	}
+}
+
+func TestAnalyseLexerForPatch_EvictsOldestEntriesBeyondCapacity(t *testing.T) {
+	analyseLexerCacheMu.Lock()
+	analyseLexerCacheEntries = map[*Patch]*list.Element{}
+	analyseLexerCacheOrder = list.New()
+	analyseLexerCacheMu.Unlock()
+
+	patches := make([]*Patch, analyseLexerCacheMaxEntries+1)
+	for i := range patches {
+		patches[i] = newTestPatchForAnalysis([]*PatchLine{{Content: "+package main"}})
+		analyseLexerForPatch(patches[i])
+	}
+
+	analyseLexerCacheMu.Lock()
+	_, firstStillCached := analyseLexerCacheEntries[patches[0]]
+	_, lastStillCached := analyseLexerCacheEntries[patches[len(patches)-1]]
+	cacheSize := len(analyseLexerCacheEntries)
+	analyseLexerCacheMu.Unlock()
+
+	if firstStillCached {
+		t.Errorf("expected the oldest patch to have been evicted once the cache exceeded its capacity")
+	}
+	if !lastStillCached {
+		t.Errorf("expected the most recently analysed patch to still be cached")
+	}
+	if cacheSize > analyseLexerCacheMaxEntries {
+		t.Errorf("expected cache size to stay at or below %d, got %d", analyseLexerCacheMaxEntries, cacheSize)
+	}
+}
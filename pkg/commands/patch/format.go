@@ -31,10 +31,18 @@ type patchPresenter struct {
 
 // formats the patch as a plain string
 func formatPlain(patch *Patch) string {
+	filename := ExtractFilenameFromHeader(patch.header)
+
 	presenter := &patchPresenter{
 		patch:          patch,
 		plain:          true,
 		incLineIndices: set.New[int](),
+		// A MonochromeFormatter-backed highlighter renders tokens back out
+		// unchanged, so this is a real "no-ANSI" path (tokenise, then emit
+		// the same bytes) rather than the colored path with its ANSI escapes
+		// stripped out after the fact - which matters here because the
+		// result is handed to `git apply` and can't carry any styling.
+		highlighter: NewSyntaxHighlighterWithConfig(filename, HighlighterConfig{Formatter: MonochromeFormatter{}}),
 	}
 	return presenter.format()
 }
@@ -59,6 +67,9 @@ type FormatViewOpts struct {
 	LineSelectMode bool
 	// view width for full-line backgrounds (0 means no padding)
 	ViewWidth int
+	// HighlighterConfig configures the syntax highlighter's theme and diff
+	// colours. Zero value falls back to DefaultHighlighterConfig.
+	HighlighterConfig HighlighterConfig
 }
 
 // formats the patch for rendering within a view, meaning it's coloured and
@@ -69,12 +80,11 @@ func formatView(patch *Patch, opts FormatViewOpts) string {
 		includedLineIndices = set.New[int]()
 	}
 
-	// Extract filename from header for syntax highlighting
+	// Extract filename from header for syntax highlighting. Even when this
+	// comes up empty (e.g. a /dev/null deletion), NewSyntaxHighlighterForPatch
+	// still gets a chance to resolve a lexer via content analysis.
 	filename := ExtractFilenameFromHeader(patch.header)
-	var highlighter *SyntaxHighlighter
-	if filename != "" {
-		highlighter = NewSyntaxHighlighter(filename)
-	}
+	highlighter := NewSyntaxHighlighterForPatch(patch, filename, opts.HighlighterConfig)
 
 	presenter := &patchPresenter{
 		patch:            patch,
@@ -156,12 +166,15 @@ func (self *patchPresenter) format() string {
 				),
 		)
 
-		for _, line := range hunk.bodyLines {
+		wordDiffSpans := wordDiffSpansForHunk(self.highlighter, hunk.bodyLines)
+		highlightedBodyLines := self.highlightHunkBodyLines(hunk.bodyLines)
+
+		for i, line := range hunk.bodyLines {
 			style := self.patchLineStyle(line)
 			if line.IsChange() {
-				appendLine(self.formatLine(line.Content, style, lineIdx, line.Kind))
+				appendLine(self.formatLine(line.Content, style, lineIdx, line.Kind, wordDiffSpans[i], highlightedBodyLines, i))
 			} else {
-				appendLine(self.formatLineWithKind(line.Content, style, false, line.Kind, lineIdx))
+				appendLine(self.formatLineWithKind(line.Content, style, false, line.Kind, lineIdx, nil, highlightedBodyLines, i))
 			}
 		}
 	}
@@ -169,6 +182,121 @@ func (self *patchPresenter) format() string {
 	return stringBuilder.String()
 }
 
+// highlightHunkBodyLines batch-highlights all of a hunk's body lines
+// together via HighlightLines, so a stateful lexer (one that tracks e.g.
+// multi-line strings or block comments) sees the hunk as continuous code
+// instead of re-tokenising each line from a blank state - the motivation
+// HighlightLines was built for. Returns nil for plain output or when the
+// presenter has no highlighter, in which case callers fall back to
+// highlighting each line individually.
+//
+// Each line is first looked up in sharedHighlightLineCache under the same
+// key HighlightLineWithBackground would use; only when at least one line
+// misses do we pay for a fresh HighlightLines tokenise of the whole hunk,
+// and its results are then written back into the cache. Without this, a
+// re-render of an unchanged hunk (scroll, resize, selection move) would
+// always retokenise from scratch, since HighlightLines itself never
+// consults the cache.
+func (self *patchPresenter) highlightHunkBodyLines(bodyLines []*PatchLine) []string {
+	if self.plain || self.highlighter == nil {
+		return nil
+	}
+
+	codeLines := make([]string, len(bodyLines))
+	bgs := make([]DiffBackground, len(bodyLines))
+	keys := make([]highlightCacheKey, len(bodyLines))
+	cached := make([]string, len(bodyLines))
+	allCached := true
+	for i, line := range bodyLines {
+		if len(line.Content) < 2 {
+			continue
+		}
+		codeLines[i] = line.Content[1:]
+
+		switch line.Kind {
+		case ADDITION:
+			bgs[i] = AdditionBackground
+		case DELETION:
+			bgs[i] = DeletionBackground
+		default:
+			bgs[i] = NoDiffBackground
+		}
+
+		keys[i] = self.highlighter.cacheKeyFor(bgs[i], codeLines[i])
+		if value, ok := sharedHighlightLineCache.get(keys[i]); ok {
+			cached[i] = value
+		} else {
+			allCached = false
+		}
+	}
+
+	if allCached {
+		return cached
+	}
+
+	highlighted := self.highlighter.HighlightLines(codeLines, bgs)
+	for i, line := range bodyLines {
+		if len(line.Content) < 2 {
+			continue
+		}
+		sharedHighlightLineCache.put(keys[i], highlighted[i])
+	}
+	return highlighted
+}
+
+// wordDiffSpansForHunk scans a hunk's body lines for contiguous
+// deletion-then-addition blocks of equal length (the common "line X removed,
+// line X added back changed" shape) and computes intra-line word-diff spans
+// for each paired line, keyed by that line's index into bodyLines.
+func wordDiffSpansForHunk(highlighter *SyntaxHighlighter, bodyLines []*PatchLine) map[int][]tokenSpan {
+	spans := map[int][]tokenSpan{}
+	if highlighter == nil {
+		return spans
+	}
+
+	i := 0
+	for i < len(bodyLines) {
+		if bodyLines[i].Kind != DELETION {
+			i++
+			continue
+		}
+
+		delStart := i
+		for i < len(bodyLines) && bodyLines[i].Kind == DELETION {
+			i++
+		}
+		delCount := i - delStart
+
+		addStart := i
+		for i < len(bodyLines) && bodyLines[i].Kind == ADDITION {
+			i++
+		}
+		addCount := i - addStart
+
+		if delCount == 0 || delCount != addCount {
+			continue
+		}
+
+		for offset := 0; offset < delCount; offset++ {
+			delLine := bodyLines[delStart+offset]
+			addLine := bodyLines[addStart+offset]
+			if len(delLine.Content) < 2 || len(addLine.Content) < 2 {
+				continue
+			}
+
+			delSpans, addSpans := computeWordDiffSpans(highlighter, delLine.Content[1:], addLine.Content[1:])
+			if len(delSpans) > 0 {
+				spans[delStart+offset] = delSpans
+			}
+			if len(addSpans) > 0 {
+				spans[addStart+offset] = addSpans
+			}
+		}
+	}
+
+	return spans
+}
+
 func (self *patchPresenter) patchLineStyle(patchLine *PatchLine) style.TextStyle {
 	switch patchLine.Kind {
 	case ADDITION:
@@ -180,10 +308,10 @@ func (self *patchPresenter) patchLineStyle(patchLine *PatchLine) style.TextStyle
 	}
 }
 
-func (self *patchPresenter) formatLine(str string, textStyle style.TextStyle, index int, lineKind PatchLineKind) string {
+func (self *patchPresenter) formatLine(str string, textStyle style.TextStyle, index int, lineKind PatchLineKind, wordDiffSpans []tokenSpan, highlightedBodyLines []string, bodyIdx int) string {
 	included := self.incLineIndices.Includes(index)
 
-	return self.formatLineWithKind(str, textStyle, included, lineKind, index)
+	return self.formatLineWithKind(str, textStyle, included, lineKind, index, wordDiffSpans, highlightedBodyLines, bodyIdx)
 }
 
 // 'selected' means you've got it highlighted with your cursor
@@ -191,12 +319,23 @@ func (self *patchPresenter) formatLine(str string, textStyle style.TextStyle, in
 // building a patch)
 // lineKind is used to determine the background color for diff lines
 func (self *patchPresenter) formatLineAux(str string, textStyle style.TextStyle, included bool, lineIdx int) string {
-	return self.formatLineWithKind(str, textStyle, included, CONTEXT, lineIdx)
+	return self.formatLineWithKind(str, textStyle, included, CONTEXT, lineIdx, nil, nil, -1)
 }
 
-func (self *patchPresenter) formatLineWithKind(str string, textStyle style.TextStyle, included bool, lineKind PatchLineKind, lineIdx int) string {
+// wordDiffSpans, when non-empty, marks byte ranges within str (after the
+// +/- prefix) that changed relative to this line's paired addition/deletion
+// counterpart, and should be rendered with the brighter word-diff
+// background instead of the plain diff background.
+//
+// highlightedBodyLines/bodyIdx, when bodyIdx >= 0, give this line's already
+// syntax-highlighted code content from a batch highlightHunkBodyLines call
+// over the whole hunk, so lines get rendered with cross-line lexer context
+// instead of being re-tokenised in isolation. Lines with word-diff spans
+// still go through the per-line word-diff highlighting path, since that
+// needs span-aware highlighting HighlightLines doesn't provide.
+func (self *patchPresenter) formatLineWithKind(str string, textStyle style.TextStyle, included bool, lineKind PatchLineKind, lineIdx int, wordDiffSpans []tokenSpan, highlightedBodyLines []string, bodyIdx int) string {
 	if self.plain {
-		return str
+		return self.formatLinePlain(str, wordDiffSpans)
 	}
 
 	firstCharStyle := textStyle
@@ -225,7 +364,15 @@ func (self *patchPresenter) formatLineWithKind(str string, textStyle style.TextS
 		}
 
 		if self.highlighter != nil && codeContent != "" {
-			highlightedCode := self.highlighter.HighlightLineWithBackground(codeContent, bg)
+			var highlightedCode string
+			switch {
+			case len(wordDiffSpans) > 0:
+				highlightedCode = self.highlighter.HighlightLineWithWordDiff(codeContent, bg, wordDiffSpans)
+			case bodyIdx >= 0 && bodyIdx < len(highlightedBodyLines):
+				highlightedCode = highlightedBodyLines[bodyIdx]
+			default:
+				highlightedCode = self.highlighter.HighlightLineWithBackground(codeContent, bg)
+			}
 			result = firstCharStyle.Sprint(str[:1]) + highlightedCode
 		} else {
 			result = firstCharStyle.Sprint(str[:1]) + textStyle.Sprint(str[1:])
@@ -242,3 +389,19 @@ func (self *patchPresenter) formatLineWithKind(str string, textStyle style.TextS
 func (self *patchPresenter) padToWidth(line string, bg DiffBackground) string {
 	return line
 }
+
+// formatLinePlain renders str for formatPlain's no-ANSI path: the leading
+// +/-/space marker is kept as-is, and the code content is passed through
+// self.highlighter (configured with MonochromeFormatter), which tokenises it
+// and emits each token's text back out unchanged.
+func (self *patchPresenter) formatLinePlain(str string, wordDiffSpans []tokenSpan) string {
+	if len(str) < 2 || self.highlighter == nil {
+		return str
+	}
+
+	codeContent := str[1:]
+	if len(wordDiffSpans) > 0 {
+		return str[:1] + self.highlighter.HighlightLineWithWordDiff(codeContent, NoDiffBackground, wordDiffSpans)
+	}
+	return str[:1] + self.highlighter.HighlightLineWithBackground(codeContent, NoDiffBackground)
+}
@@ -0,0 +1,60 @@
+package patch
+
+import "testing"
+
+func newTestPatchForFormat(bodyLines []*PatchLine) *Patch {
+	return &Patch{
+		header: []string{"diff --git a/main.go b/main.go", "+++ b/main.go"},
+		hunks: []hunk{
+			{bodyLines: bodyLines},
+		},
+	}
+}
+
+func sharedHighlightLineCacheSize() int {
+	sharedHighlightLineCache.mu.Lock()
+	defer sharedHighlightLineCache.mu.Unlock()
+	return len(sharedHighlightLineCache.entries)
+}
+
+func TestFormatView_SecondRenderOfSamePatchReusesSharedCache(t *testing.T) {
+	patch := newTestPatchForFormat([]*PatchLine{
+		{Kind: CONTEXT, Content: ` package main`},
+		{Kind: ADDITION, Content: `+func main() {}`},
+	})
+	opts := FormatViewOpts{}
+
+	formatView(patch, opts)
+	sizeAfterFirstRender := sharedHighlightLineCacheSize()
+
+	formatView(patch, opts)
+	sizeAfterSecondRender := sharedHighlightLineCacheSize()
+
+	if sizeAfterSecondRender != sizeAfterFirstRender {
+		t.Errorf("expected re-rendering the same patch to reuse cached lines rather than grow the cache, size went %d -> %d", sizeAfterFirstRender, sizeAfterSecondRender)
+	}
+}
+
+func TestHighlightHunkBodyLines_PartialCacheHitStillHighlightsAllLines(t *testing.T) {
+	highlighter := NewSyntaxHighlighter("main.go")
+	presenter := &patchPresenter{highlighter: highlighter}
+
+	bodyLines := []*PatchLine{
+		{Kind: CONTEXT, Content: ` package main`},
+		{Kind: ADDITION, Content: `+func main() {}`},
+	}
+
+	first := presenter.highlightHunkBodyLines(bodyLines)
+
+	bodyLines = append(bodyLines, &PatchLine{Kind: ADDITION, Content: `+var x = 1`})
+	second := presenter.highlightHunkBodyLines(bodyLines)
+
+	if len(second) != len(bodyLines) {
+		t.Fatalf("expected one highlighted line per body line, got %d for %d lines", len(second), len(bodyLines))
+	}
+	for i := range first {
+		if second[i] != first[i] {
+			t.Errorf("expected line %d to render identically once cached, got %q, want %q", i, second[i], first[i])
+		}
+	}
+}
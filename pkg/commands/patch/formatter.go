@@ -0,0 +1,133 @@
+package patch
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// Formatter renders a single highlighted token for one specific output
+// target (a truecolor terminal, a 256-colour terminal, an HTML export,
+// etc). SyntaxHighlighter always renders through a Formatter rather than
+// building ANSI escapes directly, so swapping the Formatter is how
+// truecolor, 256-colour, "no colour", and HTML output all share the same
+// tokenising/diffing pipeline.
+type Formatter interface {
+	// FormatToken renders text, given its resolved foreground colour
+	// (fg/hasFg), its diff or word-diff background (bg/hasBg), and any
+	// bold/italic/underline decoration from the active chroma style.
+	FormatToken(text string, fg rgbColour, hasFg bool, bold, italic, underline bool, bg rgbColour, hasBg bool) string
+}
+
+// TrueColorFormatter renders 24-bit ANSI escape sequences. This is the
+// default on terminals that advertise truecolor support.
+type TrueColorFormatter struct{}
+
+func (TrueColorFormatter) FormatToken(text string, fg rgbColour, hasFg bool, bold, italic, underline bool, bg rgbColour, hasBg bool) string {
+	var fgCode, bgCode string
+	if hasFg {
+		fgCode = fmt.Sprintf("38;2;%d;%d;%d", fg.r, fg.g, fg.b)
+	}
+	if hasBg {
+		bgCode = fmt.Sprintf("48;2;%d;%d;%d", bg.r, bg.g, bg.b)
+	}
+	return formatAnsiToken(text, fgCode, bgCode, bold, italic, underline)
+}
+
+// Color256Formatter renders 8-bit xterm-256 ANSI escape sequences, mapping
+// each RGB colour to its nearest palette entry. Used as the default on
+// terminals that don't advertise truecolor support.
+type Color256Formatter struct{}
+
+func (Color256Formatter) FormatToken(text string, fg rgbColour, hasFg bool, bold, italic, underline bool, bg rgbColour, hasBg bool) string {
+	var fgCode, bgCode string
+	if hasFg {
+		fgCode = fmt.Sprintf("38;5;%d", rgbToXterm256(fg))
+	}
+	if hasBg {
+		bgCode = fmt.Sprintf("48;5;%d", rgbToXterm256(bg))
+	}
+	return formatAnsiToken(text, fgCode, bgCode, bold, italic, underline)
+}
+
+// formatAnsiToken wraps text in a single SGR escape sequence combining
+// fgCode and bgCode (either may be empty to omit it) with any decoration
+// codes, shared by TrueColorFormatter and Color256Formatter since they only
+// differ in how they encode colours.
+func formatAnsiToken(text string, fgCode, bgCode string, bold, italic, underline bool) string {
+	var codes []string
+	if fgCode != "" {
+		codes = append(codes, fgCode)
+	}
+	if bgCode != "" {
+		codes = append(codes, bgCode)
+	}
+	codes = append(codes, decorationCodes(bold, italic, underline)...)
+
+	if len(codes) == 0 {
+		return text
+	}
+	return fmt.Sprintf("\x1b[%sm%s\x1b[0m", strings.Join(codes, ";"), text)
+}
+
+// MonochromeFormatter strips all colour and decoration, returning token
+// text unchanged. Useful for callers that want the plain +/- diff structure
+// without any ANSI escapes at all.
+type MonochromeFormatter struct{}
+
+func (MonochromeFormatter) FormatToken(text string, _ rgbColour, _ bool, _, _, _ bool, _ rgbColour, _ bool) string {
+	return text
+}
+
+// HTMLFormatter renders tokens as inline-styled HTML spans, for "copy as
+// HTML"-style export features.
+type HTMLFormatter struct{}
+
+func (HTMLFormatter) FormatToken(text string, fg rgbColour, hasFg bool, bold, italic, underline bool, bg rgbColour, hasBg bool) string {
+	escaped := html.EscapeString(text)
+
+	var styles []string
+	if hasFg {
+		styles = append(styles, fmt.Sprintf("color:#%02x%02x%02x", fg.r, fg.g, fg.b))
+	}
+	if hasBg {
+		styles = append(styles, fmt.Sprintf("background-color:#%02x%02x%02x", bg.r, bg.g, bg.b))
+	}
+	if bold {
+		styles = append(styles, "font-weight:bold")
+	}
+	if italic {
+		styles = append(styles, "font-style:italic")
+	}
+	if underline {
+		styles = append(styles, "text-decoration:underline")
+	}
+
+	if len(styles) == 0 {
+		return escaped
+	}
+	return fmt.Sprintf(`<span style="%s">%s</span>`, strings.Join(styles, ";"), escaped)
+}
+
+func decorationCodes(bold, italic, underline bool) []string {
+	var codes []string
+	if bold {
+		codes = append(codes, "1")
+	}
+	if italic {
+		codes = append(codes, "3")
+	}
+	if underline {
+		codes = append(codes, "4")
+	}
+	return codes
+}
+
+// defaultFormatterForColorDepth picks the ANSI formatter matching the
+// terminal's detected colour capability.
+func defaultFormatterForColorDepth(depth ColorDepth) Formatter {
+	if depth == Color256Depth {
+		return Color256Formatter{}
+	}
+	return TrueColorFormatter{}
+}
@@ -0,0 +1,73 @@
+package patch
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMonochromeFormatter_StripsAllStyling(t *testing.T) {
+	highlighter := NewSyntaxHighlighterWithConfig("main.go", HighlighterConfig{Formatter: MonochromeFormatter{}})
+
+	out := highlighter.HighlightLineWithBackground(`x := "hello"`, AdditionBackground)
+
+	if out != `x := "hello"` {
+		t.Errorf("expected monochrome output to equal input unchanged, got %q", out)
+	}
+}
+
+func TestHTMLFormatter_WrapsColouredTokensInSpans(t *testing.T) {
+	highlighter := NewSyntaxHighlighterWithConfig("main.go", HighlighterConfig{Formatter: HTMLFormatter{}})
+
+	out := highlighter.HighlightLineWithBackground(`x := 1`, AdditionBackground)
+
+	if out == "" {
+		t.Fatalf("expected non-empty HTML output")
+	}
+	if !containsSpanTag(out) {
+		t.Errorf("expected HTML output to contain at least one <span>, got %q", out)
+	}
+}
+
+func TestColor256Formatter_UsesPaletteIndices(t *testing.T) {
+	highlighter := NewSyntaxHighlighterWithConfig("main.go", HighlighterConfig{Formatter: Color256Formatter{}})
+
+	out := highlighter.HighlightLineWithBackground("x", AdditionBackground)
+
+	if !strings.Contains(out, "48;5;") {
+		t.Errorf("expected a 256-colour background escape to be applied, got %q", out)
+	}
+}
+
+func TestFormatLinePlain_RoundTripsContentWithoutANSI(t *testing.T) {
+	presenter := &patchPresenter{
+		plain:       true,
+		highlighter: NewSyntaxHighlighterWithConfig("main.go", HighlighterConfig{Formatter: MonochromeFormatter{}}),
+	}
+
+	line := `+fmt.Println("hello, world")`
+	out := presenter.formatLinePlain(line, nil)
+
+	if out != line {
+		t.Errorf("expected formatLinePlain to round-trip %q unchanged, got %q", line, out)
+	}
+}
+
+func TestFormatLinePlain_ShortLineReturnedAsIs(t *testing.T) {
+	presenter := &patchPresenter{
+		plain:       true,
+		highlighter: NewSyntaxHighlighterWithConfig("main.go", HighlighterConfig{Formatter: MonochromeFormatter{}}),
+	}
+
+	if out := presenter.formatLinePlain("+", nil); out != "+" {
+		t.Errorf("expected a single-character line to be returned as-is, got %q", out)
+	}
+}
+
+func containsSpanTag(s string) bool {
+	for i := 0; i+6 <= len(s); i++ {
+		if s[i:i+6] == "<span>" || s[i:i+5] == "<span" {
+			return true
+		}
+	}
+	return false
+}
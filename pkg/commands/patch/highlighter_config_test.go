@@ -0,0 +1,97 @@
+package patch
+
+import (
+	"testing"
+)
+
+func TestDefaultHighlighterConfig_MatchesOriginalHardCodedValues(t *testing.T) {
+	config := DefaultHighlighterConfig()
+
+	if config.Theme != "dracula" {
+		t.Errorf("expected default theme %q, got %q", "dracula", config.Theme)
+	}
+	if config.AdditionBackground != "#004d24" {
+		t.Errorf("expected default addition background %q, got %q", "#004d24", config.AdditionBackground)
+	}
+	if config.DeletionBackground != "#4d0018" {
+		t.Errorf("expected default deletion background %q, got %q", "#4d0018", config.DeletionBackground)
+	}
+	if config.SelectedLineBackground != "#3c3c3c" {
+		t.Errorf("expected default selected-line background %q, got %q", "#3c3c3c", config.SelectedLineBackground)
+	}
+	if config.UseStyleDecorations == nil || !*config.UseStyleDecorations {
+		t.Errorf("expected UseStyleDecorations to default to true")
+	}
+}
+
+func TestMergeHighlighterConfigDefaults_PartialConfigKeepsAllDefaults(t *testing.T) {
+	config := mergeHighlighterConfigDefaults(HighlighterConfig{Theme: "monokai"}, DefaultHighlighterConfig())
+
+	if config.Theme != "monokai" {
+		t.Errorf("expected caller-supplied theme to be kept, got %q", config.Theme)
+	}
+	if config.UseStyleDecorations == nil || !*config.UseStyleDecorations {
+		t.Errorf("expected UseStyleDecorations to still default to true for a partial config, got %v", config.UseStyleDecorations)
+	}
+	if config.ColorDepth == autoColorDepth {
+		t.Errorf("expected ColorDepth to be resolved to a concrete depth for a partial config, got autoColorDepth")
+	}
+	if config.AdditionBackground != "#004d24" {
+		t.Errorf("expected default addition background to be kept, got %q", config.AdditionBackground)
+	}
+}
+
+func TestMergeHighlighterConfigDefaults_ExplicitFalseIsNotOverridden(t *testing.T) {
+	config := mergeHighlighterConfigDefaults(HighlighterConfig{UseStyleDecorations: boolPtr(false)}, DefaultHighlighterConfig())
+
+	if config.UseStyleDecorations == nil || *config.UseStyleDecorations {
+		t.Errorf("expected an explicit UseStyleDecorations=false to be preserved, got %v", config.UseStyleDecorations)
+	}
+}
+
+func TestDetectColorDepth_TrueColorEnv(t *testing.T) {
+	t.Setenv("COLORTERM", "truecolor")
+	t.Setenv("TERM", "xterm")
+
+	if got := detectColorDepth(); got != TrueColorDepth {
+		t.Errorf("expected TrueColorDepth when COLORTERM=truecolor, got %v", got)
+	}
+}
+
+func TestDetectColorDepth_FallsBackTo256(t *testing.T) {
+	t.Setenv("COLORTERM", "")
+	t.Setenv("TERM", "xterm-256color")
+
+	if got := detectColorDepth(); got != Color256Depth {
+		t.Errorf("expected Color256Depth when COLORTERM is unset, got %v", got)
+	}
+}
+
+func TestParseRGB_ValidAndInvalidHex(t *testing.T) {
+	fallback := rgbColour{1, 2, 3}
+
+	if got := parseRGB("#ff0080", fallback); got != (rgbColour{0xff, 0x00, 0x80}) {
+		t.Errorf("expected parsed rgbColour{255,0,128}, got %+v", got)
+	}
+	if got := parseRGB("ff0080", fallback); got != (rgbColour{0xff, 0x00, 0x80}) {
+		t.Errorf("expected parseRGB to tolerate a missing leading '#', got %+v", got)
+	}
+	if got := parseRGB("", fallback); got != fallback {
+		t.Errorf("expected empty hex to fall back to %+v, got %+v", fallback, got)
+	}
+	if got := parseRGB("not-a-colour", fallback); got != fallback {
+		t.Errorf("expected malformed hex to fall back to %+v, got %+v", fallback, got)
+	}
+}
+
+func TestRGBToXterm256_KnownIndices(t *testing.T) {
+	if got := rgbToXterm256(rgbColour{0, 0, 0}); got != 16 {
+		t.Errorf("expected pure black to map to cube index 16, got %d", got)
+	}
+	if got := rgbToXterm256(rgbColour{255, 255, 255}); got != 231 {
+		t.Errorf("expected pure white to map to cube index 231, got %d", got)
+	}
+	if got := rgbToXterm256(rgbColour{128, 128, 128}); got < 232 {
+		t.Errorf("expected a neutral grey to map into the grayscale ramp (>=232), got %d", got)
+	}
+}
@@ -0,0 +1,111 @@
+package patch
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+)
+
+// highlightCacheMaxEntries bounds the shared LRU's size so that scrolling
+// through many large diffs over a long session doesn't grow memory
+// unbounded.
+const highlightCacheMaxEntries = 20000
+
+// highlightCacheKey identifies a single highlighted line. Two renders
+// produce the same output only if they agree on the lexer, style,
+// formatter, decoration/colour-depth configuration, the actual resolved
+// diff background colours, the diff background slot and the raw content -
+// all of it has to be part of the key, since highlightLineCache is a single
+// shared, package-level cache and two SyntaxHighlighters with different
+// HighlighterConfigs (e.g. a user-overridden AdditionBackground, or an
+// HTMLFormatter highlighter next to a TrueColorFormatter one) can otherwise
+// collide on the same lexer/style pair and silently serve each other's
+// cached output.
+type highlightCacheKey struct {
+	lexerName           string
+	styleName           string
+	formatterType       string
+	useStyleDecorations bool
+	additionBg          rgbColour
+	deletionBg          rgbColour
+	selectedBg          rgbColour
+	bg                  DiffBackground
+	line                string
+}
+
+// cacheKeyFor builds the highlightCacheKey for a line rendered by h, folding
+// in everything about h's configuration that can change its output.
+func (h *SyntaxHighlighter) cacheKeyFor(bg DiffBackground, code string) highlightCacheKey {
+	return highlightCacheKey{
+		lexerName:           h.LexerName(),
+		styleName:           h.StyleName(),
+		formatterType:       fmt.Sprintf("%T", h.formatter),
+		useStyleDecorations: *h.config.UseStyleDecorations,
+		additionBg:          h.additionBackground,
+		deletionBg:          h.deletionBackground,
+		selectedBg:          h.selectedLineBackground,
+		bg:                  bg,
+		line:                code,
+	}
+}
+
+type highlightCacheEntry struct {
+	key   highlightCacheKey
+	value string
+}
+
+// highlightLineCache is a shared, size-bounded LRU cache mapping a
+// highlightCacheKey to its pre-formatted ANSI output.
+type highlightLineCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[highlightCacheKey]*list.Element
+	order    *list.List // front = most recently used
+}
+
+func newHighlightLineCache(capacity int) *highlightLineCache {
+	return &highlightLineCache{
+		capacity: capacity,
+		entries:  map[highlightCacheKey]*list.Element{},
+		order:    list.New(),
+	}
+}
+
+func (c *highlightLineCache) get(key highlightCacheKey) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*highlightCacheEntry).value, true
+}
+
+func (c *highlightLineCache) put(key highlightCacheKey, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*highlightCacheEntry).value = value
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	elem := c.order.PushFront(&highlightCacheEntry{key: key, value: value})
+	c.entries[key] = elem
+
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		if oldest != nil {
+			c.order.Remove(oldest)
+			delete(c.entries, oldest.Value.(*highlightCacheEntry).key)
+		}
+	}
+}
+
+// sharedHighlightLineCache is consulted by SyntaxHighlighter before falling
+// back to a fresh Chroma tokenise, so re-rendering the same patch doesn't
+// re-highlight lines that haven't changed since the last render.
+var sharedHighlightLineCache = newHighlightLineCache(highlightCacheMaxEntries)
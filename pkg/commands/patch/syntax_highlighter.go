@@ -1,9 +1,13 @@
 package patch
 
 import (
-	"fmt"
+	"container/list"
+	"math"
+	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/alecthomas/chroma/v2"
 	"github.com/alecthomas/chroma/v2/lexers"
@@ -12,29 +16,285 @@ import (
 
 // SyntaxHighlighter provides syntax highlighting for code using chroma
 type SyntaxHighlighter struct {
-	lexer chroma.Lexer
-	style *chroma.Style
+	lexer     chroma.Lexer
+	style     *chroma.Style
+	config    HighlighterConfig
+	formatter Formatter
+
+	additionBackground     rgbColour
+	deletionBackground     rgbColour
+	selectedLineBackground rgbColour
+}
+
+// HighlighterConfig holds the user-configurable options for syntax
+// highlighting, populated from lazygit's user config.
+type HighlighterConfig struct {
+	// Theme is the name of the chroma style to use (e.g. "dracula",
+	// "monokai", "github"). Falls back to "dracula" when empty or
+	// unrecognised.
+	Theme string
+	// AdditionBackground overrides the background colour for added lines,
+	// given as a "#rrggbb" hex triple.
+	AdditionBackground string
+	// DeletionBackground overrides the background colour for deleted lines,
+	// given as a "#rrggbb" hex triple.
+	DeletionBackground string
+	// SelectedLineBackground overrides the background colour used for the
+	// line-select cursor, given as a "#rrggbb" hex triple.
+	SelectedLineBackground string
+	// UseStyleDecorations controls whether bold/italic/underline attributes
+	// from the chosen chroma style are honoured. A nil pointer (the zero
+	// value for a partially-populated config) defaults to true; pass
+	// a pointer to false to explicitly turn decorations off.
+	UseStyleDecorations *bool
+	// ColorDepth controls how many colours are used when rendering ANSI
+	// escape sequences. The zero value defaults to whatever
+	// detectColorDepth() finds. Only consulted when Formatter is nil.
+	ColorDepth ColorDepth
+	// Formatter, when set, overrides the Formatter implementation used to
+	// render tokens (e.g. MonochromeFormatter or HTMLFormatter instead of
+	// the terminal-capability-based ANSI default).
+	Formatter Formatter
 }
 
-// NewSyntaxHighlighter creates a new syntax highlighter for the given filename
+// ColorDepth represents the number of colours a terminal can render.
+type ColorDepth int
+
+const (
+	// autoColorDepth is ColorDepth's zero value, meaning "not set" -
+	// newSyntaxHighlighter resolves it via detectColorDepth(). It's never
+	// itself passed to defaultFormatterForColorDepth.
+	autoColorDepth ColorDepth = iota
+	// TrueColorDepth renders 24-bit RGB escape sequences.
+	TrueColorDepth
+	// Color256Depth renders 8-bit xterm-256 escape sequences, for terminals
+	// that don't support truecolor.
+	Color256Depth
+)
+
+// boolPtr returns a pointer to b, for populating HighlighterConfig's
+// pointer-typed fields with a literal.
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+// defaultThemeName is the chroma style used when no theme is configured, or
+// when a configured theme name isn't recognised by chroma.
+const defaultThemeName = "dracula"
+
+// DefaultHighlighterConfig returns the highlighter's built-in defaults,
+// matching the original hard-coded Dracula/RGB behaviour.
+func DefaultHighlighterConfig() HighlighterConfig {
+	return HighlighterConfig{
+		Theme:                  defaultThemeName,
+		AdditionBackground:     "#004d24",
+		DeletionBackground:     "#4d0018",
+		SelectedLineBackground: "#3c3c3c",
+		UseStyleDecorations:    boolPtr(true),
+		ColorDepth:             detectColorDepth(),
+	}
+}
+
+// detectColorDepth guesses the terminal's colour capability from the
+// environment, so we can gracefully fall back to 256-colour output when
+// truecolor isn't supported.
+func detectColorDepth() ColorDepth {
+	colorterm := strings.ToLower(os.Getenv("COLORTERM"))
+	if strings.Contains(colorterm, "truecolor") || strings.Contains(colorterm, "24bit") {
+		return TrueColorDepth
+	}
+
+	term := strings.ToLower(os.Getenv("TERM"))
+	if strings.Contains(term, "direct") {
+		return TrueColorDepth
+	}
+
+	return Color256Depth
+}
+
+// NewSyntaxHighlighter creates a new syntax highlighter for the given
+// filename, using the default highlighter config (Dracula style, truecolor
+// where supported).
 func NewSyntaxHighlighter(filename string) *SyntaxHighlighter {
-	// Get lexer based on filename
+	return NewSyntaxHighlighterWithConfig(filename, DefaultHighlighterConfig())
+}
+
+// NewSyntaxHighlighterWithConfig creates a new syntax highlighter for the
+// given filename using the given HighlighterConfig. Fields left at their
+// zero value fall back to DefaultHighlighterConfig's values.
+func NewSyntaxHighlighterWithConfig(filename string, config HighlighterConfig) *SyntaxHighlighter {
+	return newSyntaxHighlighter(lexers.Match(filename), config)
+}
+
+// NewSyntaxHighlighterForPatch is like NewSyntaxHighlighterWithConfig, but
+// when the filename lookup fails to resolve a lexer (common for
+// extensionless files: Dockerfile variants, shebang scripts, snippets,
+// /dev/null deletions) it falls back to analysing the patch's own content
+// with chroma's content-based analyser. The resolved lexer is cached per
+// Patch so we don't re-analyse on every render of the same patch.
+func NewSyntaxHighlighterForPatch(patch *Patch, filename string, config HighlighterConfig) *SyntaxHighlighter {
 	lexer := lexers.Match(filename)
+	if lexer == nil {
+		lexer = analyseLexerForPatch(patch)
+	}
+	return newSyntaxHighlighter(lexer, config)
+}
+
+// mergeHighlighterConfigDefaults fills in any field of config left at its
+// zero value with the corresponding value from defaults, the same way for
+// every field, so a caller-supplied partial HighlighterConfig (e.g.
+// HighlighterConfig{Theme: "monokai"}) can't accidentally disable
+// decorations or force truecolor just by not mentioning those fields.
+func mergeHighlighterConfigDefaults(config, defaults HighlighterConfig) HighlighterConfig {
+	if config.Theme == "" {
+		config.Theme = defaults.Theme
+	}
+	if config.AdditionBackground == "" {
+		config.AdditionBackground = defaults.AdditionBackground
+	}
+	if config.DeletionBackground == "" {
+		config.DeletionBackground = defaults.DeletionBackground
+	}
+	if config.SelectedLineBackground == "" {
+		config.SelectedLineBackground = defaults.SelectedLineBackground
+	}
+	if config.UseStyleDecorations == nil {
+		config.UseStyleDecorations = defaults.UseStyleDecorations
+	}
+	if config.ColorDepth == autoColorDepth {
+		config.ColorDepth = defaults.ColorDepth
+	}
+	return config
+}
+
+func newSyntaxHighlighter(lexer chroma.Lexer, config HighlighterConfig) *SyntaxHighlighter {
+	config = mergeHighlighterConfigDefaults(config, DefaultHighlighterConfig())
+
 	if lexer == nil {
 		lexer = lexers.Fallback
 	}
 	lexer = chroma.Coalesce(lexer)
 
-	// Use Dracula style (matches user's delta config)
-	style := styles.Get("dracula")
+	// styles.Get never returns nil (it substitutes chroma's own fallback for
+	// an unknown name), so look the name up in the registry directly.
+	style := styles.Registry[config.Theme]
+	if style == nil {
+		// config.Theme came from the caller (an unrecognised user-picked
+		// name), so retry the repo's own default before falling all the way
+		// back to chroma's built-in fallback style.
+		style = styles.Get(defaultThemeName)
+	}
 	if style == nil {
 		style = styles.Fallback
 	}
 
+	formatter := config.Formatter
+	if formatter == nil {
+		formatter = defaultFormatterForColorDepth(config.ColorDepth)
+	}
+
 	return &SyntaxHighlighter{
-		lexer: lexer,
-		style: style,
+		lexer:                  lexer,
+		style:                  style,
+		config:                 config,
+		formatter:              formatter,
+		additionBackground:     parseRGB(config.AdditionBackground, rgbColour{0, 77, 36}),
+		deletionBackground:     parseRGB(config.DeletionBackground, rgbColour{77, 0, 24}),
+		selectedLineBackground: parseRGB(config.SelectedLineBackground, rgbColour{60, 60, 60}),
+	}
+}
+
+// analyseLexerCacheMaxLines bounds how much of a patch's content we feed to
+// chroma's analyser, so a huge diff doesn't make every filename-miss slow.
+const analyseLexerCacheMaxLines = 200
+
+// analyseLexerCacheMaxEntries bounds the number of patches whose
+// content-analysed lexer we keep around. Without a bound, a long-running
+// lazygit session would add one permanent entry (keeping that *Patch, and
+// everything it references, alive forever) for every diff ever viewed; this
+// caps it at the analyseLexerCacheMaxEntries most recently analysed
+// patches, same strategy as sharedHighlightLineCache.
+const analyseLexerCacheMaxEntries = 256
+
+type analyseLexerCacheEntry struct {
+	patch *Patch
+	lexer chroma.Lexer
+}
+
+var (
+	analyseLexerCacheMu      sync.Mutex
+	analyseLexerCacheEntries = map[*Patch]*list.Element{}
+	analyseLexerCacheOrder   = list.New() // front = most recently used
+)
+
+// analyseLexerForPatch resolves a lexer for patch by content analysis,
+// caching the result in a size-bounded LRU so repeated renders (scrolling,
+// width changes) of the same patch don't re-run the analyser, without
+// leaking memory for every patch ever viewed in the session.
+func analyseLexerForPatch(patch *Patch) chroma.Lexer {
+	analyseLexerCacheMu.Lock()
+	defer analyseLexerCacheMu.Unlock()
+
+	if elem, ok := analyseLexerCacheEntries[patch]; ok {
+		analyseLexerCacheOrder.MoveToFront(elem)
+		return elem.Value.(*analyseLexerCacheEntry).lexer
+	}
+
+	code := reconstructCodeForAnalysis(patch, analyseLexerCacheMaxLines)
+	var lexer chroma.Lexer
+	if code != "" {
+		lexer = lexers.Analyse(code)
+	}
+
+	elem := analyseLexerCacheOrder.PushFront(&analyseLexerCacheEntry{patch: patch, lexer: lexer})
+	analyseLexerCacheEntries[patch] = elem
+
+	if analyseLexerCacheOrder.Len() > analyseLexerCacheMaxEntries {
+		oldest := analyseLexerCacheOrder.Back()
+		if oldest != nil {
+			analyseLexerCacheOrder.Remove(oldest)
+			delete(analyseLexerCacheEntries, oldest.Value.(*analyseLexerCacheEntry).patch)
+		}
+	}
+
+	return lexer
+}
+
+// reconstructCodeForAnalysis rebuilds plain code (stripping the leading
+// +/-/space diff marker) from a patch's body lines, for feeding to chroma's
+// content-based analyser. It stops after maxLines lines.
+func reconstructCodeForAnalysis(patch *Patch, maxLines int) string {
+	var buf strings.Builder
+	lines := 0
+
+	for _, hunk := range patch.hunks {
+		for _, line := range hunk.bodyLines {
+			if lines >= maxLines {
+				return buf.String()
+			}
+			if len(line.Content) < 1 {
+				continue
+			}
+			buf.WriteString(line.Content[1:])
+			buf.WriteString("\n")
+			lines++
+		}
+	}
+
+	return buf.String()
+}
+
+// LexerName returns the display name of the resolved lexer (e.g. "Go",
+// "Dockerfile"), so callers can surface the detected language in the UI.
+func (h *SyntaxHighlighter) LexerName() string {
+	if h.lexer == nil {
+		return ""
+	}
+	config := h.lexer.Config()
+	if config == nil {
+		return ""
 	}
+	return config.Name
 }
 
 // DiffBackground represents the background color for diff lines
@@ -49,90 +309,244 @@ const (
 
 // HighlightLineWithBackground highlights a line and applies a diff background color
 func (h *SyntaxHighlighter) HighlightLineWithBackground(code string, bg DiffBackground) string {
+	return h.highlightLine(code, bg, nil)
+}
+
+// HighlightLineWithWordDiff is like HighlightLineWithBackground, but also
+// brightens the background over the byte ranges in changedSpans, producing
+// the intra-line word-diff effect used for paired addition/deletion lines.
+func (h *SyntaxHighlighter) HighlightLineWithWordDiff(code string, bg DiffBackground, changedSpans []tokenSpan) string {
+	return h.highlightLine(code, bg, changedSpans)
+}
+
+func (h *SyntaxHighlighter) highlightLine(code string, bg DiffBackground, changedSpans []tokenSpan) string {
 	if h.lexer == nil || code == "" {
 		return h.applyBackgroundOnly(code, bg)
 	}
 
-	iterator, err := h.lexer.Tokenise(nil, code)
-	if err != nil {
+	// Word-diff spans are computed per render and aren't worth caching, so
+	// only consult/populate the shared cache for plain lines.
+	if len(changedSpans) == 0 {
+		key := h.cacheKeyFor(bg, code)
+		if cached, ok := sharedHighlightLineCache.get(key); ok {
+			return cached
+		}
+
+		result, ok := h.renderLine(code, bg, nil)
+		if !ok {
+			return h.applyBackgroundOnly(code, bg)
+		}
+		sharedHighlightLineCache.put(key, result)
+		return result
+	}
+
+	result, ok := h.renderLine(code, bg, changedSpans)
+	if !ok {
 		return h.applyBackgroundOnly(code, bg)
 	}
+	return result
+}
+
+// renderLine tokenises code and formats each token, applying bg and any
+// word-diff changedSpans. The second return value is false when tokenising
+// failed, in which case the caller should fall back to applyBackgroundOnly.
+func (h *SyntaxHighlighter) renderLine(code string, bg DiffBackground, changedSpans []tokenSpan) (string, bool) {
+	tokens, err := h.tokeniseWithOffsets(code)
+	if err != nil {
+		return "", false
+	}
 
 	var buf strings.Builder
-	tokens := iterator.Tokens()
+	for _, tok := range tokens {
+		tokenStyle := h.style.Get(tok.tokenType)
+		changed := len(changedSpans) > 0 && spansOverlap(changedSpans, tok.start, tok.end)
+		buf.WriteString(h.formatToken(tok.value, tokenStyle, bg, changed))
+	}
+
+	return buf.String(), true
+}
+
+// HighlightLines tokenises all of codeLines as a single buffer, so
+// stateful lexers (multi-line strings, block comments, etc.) see full
+// context instead of restarting at every line, then splits the formatted
+// result back into one string per input line. bgs gives the diff
+// background for each line; if it's shorter than codeLines the remaining
+// lines get NoDiffBackground.
+func (h *SyntaxHighlighter) HighlightLines(codeLines []string, bgs []DiffBackground) []string {
+	out := make([]string, len(codeLines))
+	bgFor := func(i int) DiffBackground {
+		if i < len(bgs) {
+			return bgs[i]
+		}
+		return NoDiffBackground
+	}
+
+	if h.lexer == nil || len(codeLines) == 0 {
+		for i, line := range codeLines {
+			out[i] = h.applyBackgroundOnly(line, bgFor(i))
+		}
+		return out
+	}
+
+	iterator, err := h.lexer.Tokenise(nil, strings.Join(codeLines, "\n"))
+	if err != nil {
+		for i, line := range codeLines {
+			out[i] = h.applyBackgroundOnly(line, bgFor(i))
+		}
+		return out
+	}
+
+	var current strings.Builder
+	lineIdx := 0
+
+	flushLine := func() {
+		if lineIdx < len(out) {
+			out[lineIdx] = current.String()
+		}
+		current.Reset()
+		lineIdx++
+	}
 
-	for _, token := range tokens {
+	for _, token := range iterator.Tokens() {
 		tokenStyle := h.style.Get(token.Type)
-		text := token.Value
+		remaining := token.Value
 
-		// Skip newlines in output
-		text = strings.TrimSuffix(text, "\n")
-		if text == "" {
-			continue
+		for {
+			newlineAt := strings.IndexByte(remaining, '\n')
+			if newlineAt < 0 {
+				if remaining != "" {
+					current.WriteString(h.formatToken(remaining, tokenStyle, bgFor(lineIdx), false))
+				}
+				break
+			}
+
+			if segment := remaining[:newlineAt]; segment != "" {
+				current.WriteString(h.formatToken(segment, tokenStyle, bgFor(lineIdx), false))
+			}
+			flushLine()
+			remaining = remaining[newlineAt+1:]
 		}
+	}
+	if lineIdx < len(out) {
+		out[lineIdx] = current.String()
+	}
 
-		// Build ANSI escape sequence with both foreground and background
-		buf.WriteString(h.formatToken(text, tokenStyle, bg))
+	return out
+}
+
+// StyleName returns the name of the chroma style in use (e.g. "dracula"),
+// used as part of the shared highlight cache's key.
+func (h *SyntaxHighlighter) StyleName() string {
+	if h.style == nil {
+		return ""
 	}
+	return h.style.Name
+}
 
-	return buf.String()
+// diffToken is a tokenised fragment of a line's code content, with its byte
+// offsets into that content. It's shared between normal highlighting (which
+// only needs the token text and type) and word-diff span computation (which
+// needs the offsets to map diffed tokens back to byte ranges).
+type diffToken struct {
+	value      string
+	tokenType  chroma.TokenType
+	start, end int
 }
 
-// formatToken applies syntax foreground color and diff background color
-func (h *SyntaxHighlighter) formatToken(text string, tokenStyle chroma.StyleEntry, bg DiffBackground) string {
-	var codes []string
+// tokeniseWithOffsets tokenises code with h.lexer, tracking each token's byte
+// offset into code. Tokens that trim down to an empty string (e.g. bare
+// newlines) are skipped, same as HighlightLineWithBackground always did.
+func (h *SyntaxHighlighter) tokeniseWithOffsets(code string) ([]diffToken, error) {
+	iterator, err := h.lexer.Tokenise(nil, code)
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens []diffToken
+	offset := 0
+	for _, token := range iterator.Tokens() {
+		raw := token.Value
+		text := strings.TrimSuffix(raw, "\n")
+		if text == "" {
+			offset += len(raw)
+			continue
+		}
 
-	// Apply foreground color from syntax highlighting
-	if tokenStyle.Colour.IsSet() {
-		r, g, b := tokenStyle.Colour.Red(), tokenStyle.Colour.Green(), tokenStyle.Colour.Blue()
-		codes = append(codes, fmt.Sprintf("38;2;%d;%d;%d", r, g, b))
+		tokens = append(tokens, diffToken{
+			value:     text,
+			tokenType: token.Type,
+			start:     offset,
+			end:       offset + len(text),
+		})
+		offset += len(raw)
 	}
 
-	// Apply background color from diff
+	return tokens, nil
+}
+
+// backgroundFor returns the configured background colour for the given kind
+// of diff line, and whether one applies at all.
+func (h *SyntaxHighlighter) backgroundFor(bg DiffBackground) (rgbColour, bool) {
 	switch bg {
 	case AdditionBackground:
-		// Subtle green background (#004d24 = RGB 0, 77, 36)
-		codes = append(codes, "48;2;0;77;36")
+		return h.additionBackground, true
 	case DeletionBackground:
-		// Subtle red background (#4d0018 = RGB 77, 0, 24)
-		codes = append(codes, "48;2;77;0;24")
+		return h.deletionBackground, true
 	case SelectedLineBackground:
-		// Subtle gray background for line selection (like vim cursorline)
-		codes = append(codes, "48;2;60;60;60")
+		return h.selectedLineBackground, true
+	default:
+		return rgbColour{}, false
 	}
+}
 
-	// Apply text decorations
-	if tokenStyle.Bold == chroma.Yes {
-		codes = append(codes, "1")
-	}
-	if tokenStyle.Italic == chroma.Yes {
-		codes = append(codes, "3")
+// wordDiffBackgroundFor returns the brighter, word-diff variant of the
+// background colour for addition/deletion lines. Only addition and deletion
+// lines get a word-diff background; other kinds never appear in a paired
+// hunk block.
+func wordDiffBackgroundFor(bg DiffBackground) (rgbColour, bool) {
+	switch bg {
+	case AdditionBackground:
+		return rgbColour{0, 153, 77}, true // #00994d
+	case DeletionBackground:
+		return rgbColour{160, 0, 48}, true // #a00030
+	default:
+		return rgbColour{}, false
 	}
-	if tokenStyle.Underline == chroma.Yes {
-		codes = append(codes, "4")
+}
+
+// formatToken resolves the foreground/background colours and decorations
+// for a single token and hands them to the active Formatter. When changed
+// is true (the token falls within a word-diff span) the brighter word-diff
+// background is used instead of the subtle diff background.
+func (h *SyntaxHighlighter) formatToken(text string, tokenStyle chroma.StyleEntry, bg DiffBackground, changed bool) string {
+	var fg rgbColour
+	hasFg := tokenStyle.Colour.IsSet()
+	if hasFg {
+		fg = rgbColour{tokenStyle.Colour.Red(), tokenStyle.Colour.Green(), tokenStyle.Colour.Blue()}
 	}
 
-	if len(codes) == 0 {
-		return text
+	var bgColour rgbColour
+	var hasBg bool
+	if changed {
+		bgColour, hasBg = wordDiffBackgroundFor(bg)
+	} else {
+		bgColour, hasBg = h.backgroundFor(bg)
 	}
 
-	return fmt.Sprintf("\x1b[%sm%s\x1b[0m", strings.Join(codes, ";"), text)
+	bold := *h.config.UseStyleDecorations && tokenStyle.Bold == chroma.Yes
+	italic := *h.config.UseStyleDecorations && tokenStyle.Italic == chroma.Yes
+	underline := *h.config.UseStyleDecorations && tokenStyle.Underline == chroma.Yes
+
+	return h.formatter.FormatToken(text, fg, hasFg, bold, italic, underline, bgColour, hasBg)
 }
 
 // applyBackgroundOnly applies just the diff background without syntax highlighting
 func (h *SyntaxHighlighter) applyBackgroundOnly(text string, bg DiffBackground) string {
-	var bgCode string
-	switch bg {
-	case AdditionBackground:
-		bgCode = "\x1b[48;2;0;77;36m"
-	case DeletionBackground:
-		bgCode = "\x1b[48;2;77;0;24m"
-	case SelectedLineBackground:
-		bgCode = "\x1b[48;2;60;60;60m"
-	default:
+	colour, ok := h.backgroundFor(bg)
+	if !ok {
 		return text
 	}
-	return bgCode + text + "\x1b[0m"
+	return h.formatter.FormatToken(text, rgbColour{}, false, false, false, false, colour, true)
 }
 
 // HighlightLine highlights a single line without diff background (for backwards compat)
@@ -140,6 +554,87 @@ func (h *SyntaxHighlighter) HighlightLine(code string) string {
 	return h.HighlightLineWithBackground(code, NoDiffBackground)
 }
 
+// rgbColour is a plain RGB triple, used for diff backgrounds that may be
+// overridden by user config rather than taken from the chroma style.
+type rgbColour struct {
+	r, g, b uint8
+}
+
+// parseRGB parses a "#rrggbb" hex triple, falling back to fallback when hex
+// is empty or malformed.
+func parseRGB(hex string, fallback rgbColour) rgbColour {
+	hex = strings.TrimPrefix(hex, "#")
+	if len(hex) != 6 {
+		return fallback
+	}
+
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return fallback
+	}
+
+	return rgbColour{
+		r: uint8(v >> 16),
+		g: uint8(v >> 8),
+		b: uint8(v),
+	}
+}
+
+// xterm256CubeLevels are the 6 intensity levels used for each channel of the
+// 6x6x6 xterm-256 colour cube (indices 16-231).
+var xterm256CubeLevels = [6]int{0, 95, 135, 175, 215, 255}
+
+// rgbToXterm256 maps a truecolor RGB triple to the nearest xterm-256 palette
+// index, for terminals that only support 256 colors. It considers both the
+// 6x6x6 colour cube and the 24-step grayscale ramp and returns whichever is
+// closer.
+func rgbToXterm256(colour rgbColour) int {
+	r, g, b := int(colour.r), int(colour.g), int(colour.b)
+
+	nearestLevel := func(v int) (level, idx int) {
+		bestIdx := 0
+		bestDist := math.MaxInt32
+		for i, l := range xterm256CubeLevels {
+			dist := (v - l) * (v - l)
+			if dist < bestDist {
+				bestDist = dist
+				bestIdx = i
+			}
+		}
+		return xterm256CubeLevels[bestIdx], bestIdx
+	}
+
+	rLevel, rIdx := nearestLevel(r)
+	gLevel, gIdx := nearestLevel(g)
+	bLevel, bIdx := nearestLevel(b)
+	cubeDist := sqDist(r, g, b, rLevel, gLevel, bLevel)
+	cubeIndex := 16 + 36*rIdx + 6*gIdx + bIdx
+
+	// Grayscale ramp: 24 steps from #080808 to #eeeeee, indices 232-255.
+	gray := (r + g + b) / 3
+	// Ramp step i has level 8+10*i; pick the nearest step.
+	grayIdx := (gray - 3) / 10
+	if grayIdx < 0 {
+		grayIdx = 0
+	}
+	if grayIdx > 23 {
+		grayIdx = 23
+	}
+	grayLevel := 8 + 10*grayIdx
+	grayDist := sqDist(r, g, b, grayLevel, grayLevel, grayLevel)
+	grayIndex := 232 + grayIdx
+
+	if grayDist < cubeDist {
+		return grayIndex
+	}
+	return cubeIndex
+}
+
+func sqDist(r1, g1, b1, r2, g2, b2 int) int {
+	dr, dg, db := r1-r2, g1-g2, b1-b2
+	return dr*dr + dg*dg + db*db
+}
+
 // ExtractFilenameFromHeader extracts the filename from a patch header
 // Header format: "diff --git a/path/to/file b/path/to/file"
 // or "+++ b/path/to/file"
@@ -0,0 +1,107 @@
+package patch
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestHighlightLineWithBackground_CachesRepeatedLines(t *testing.T) {
+	highlighter := NewSyntaxHighlighter("main.go")
+
+	first := highlighter.HighlightLineWithBackground("x := 1", AdditionBackground)
+	second := highlighter.HighlightLineWithBackground("x := 1", AdditionBackground)
+
+	if first != second {
+		t.Errorf("expected cached result to match fresh render, got %q vs %q", first, second)
+	}
+
+	key := highlighter.cacheKeyFor(AdditionBackground, "x := 1")
+	if _, ok := sharedHighlightLineCache.get(key); !ok {
+		t.Errorf("expected line to be populated in the shared cache")
+	}
+}
+
+func TestHighlightLineWithBackground_DistinctConfigsDoNotShareCacheEntries(t *testing.T) {
+	defaultHighlighter := NewSyntaxHighlighter("main.go")
+	overriddenHighlighter := NewSyntaxHighlighterWithConfig("main.go", HighlighterConfig{AdditionBackground: "#ff0000"})
+	htmlHighlighter := NewSyntaxHighlighterWithConfig("main.go", HighlighterConfig{Formatter: HTMLFormatter{}})
+
+	defaultOut := defaultHighlighter.HighlightLineWithBackground("x := 1", AdditionBackground)
+	overriddenOut := overriddenHighlighter.HighlightLineWithBackground("x := 1", AdditionBackground)
+	htmlOut := htmlHighlighter.HighlightLineWithBackground("x := 1", AdditionBackground)
+
+	if defaultOut == overriddenOut {
+		t.Errorf("expected a highlighter with an overridden AdditionBackground to produce different output, got the same %q for both", defaultOut)
+	}
+	if defaultOut == htmlOut {
+		t.Errorf("expected an HTMLFormatter highlighter to produce different output than the ANSI default, got the same %q for both", defaultOut)
+	}
+}
+
+func TestHighlightLines_SplitsBackIntoOriginalLines(t *testing.T) {
+	highlighter := NewSyntaxHighlighter("main.go")
+
+	lines := []string{"package main", "", "func main() {}"}
+	bgs := []DiffBackground{NoDiffBackground, NoDiffBackground, AdditionBackground}
+
+	out := highlighter.HighlightLines(lines, bgs)
+
+	if len(out) != len(lines) {
+		t.Fatalf("expected %d output lines, got %d", len(lines), len(out))
+	}
+	for i, line := range out {
+		if line == "" && lines[i] != "" {
+			t.Errorf("line %d: expected non-empty highlighted output for %q", i, lines[i])
+		}
+	}
+}
+
+func generateLargeDiffLines(n int) ([]string, []DiffBackground) {
+	lines := make([]string, n)
+	bgs := make([]DiffBackground, n)
+	for i := 0; i < n; i++ {
+		lines[i] = fmt.Sprintf("var value%d = %d // line %d", i, i*2, i)
+		if i%3 == 0 {
+			bgs[i] = AdditionBackground
+		} else if i%3 == 1 {
+			bgs[i] = DeletionBackground
+		} else {
+			bgs[i] = NoDiffBackground
+		}
+	}
+	return lines, bgs
+}
+
+func BenchmarkHighlightLineWithBackground_10kLines(b *testing.B) {
+	highlighter := NewSyntaxHighlighter("main.go")
+	lines, bgs := generateLargeDiffLines(10000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j, line := range lines {
+			highlighter.HighlightLineWithBackground(line, bgs[j])
+		}
+	}
+}
+
+func BenchmarkHighlightLines_Batch10k(b *testing.B) {
+	highlighter := NewSyntaxHighlighter("main.go")
+	lines, bgs := generateLargeDiffLines(10000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		highlighter.HighlightLines(lines, bgs)
+	}
+}
+
+func BenchmarkHighlightLines_Batch10kSingleString(b *testing.B) {
+	highlighter := NewSyntaxHighlighter("main.go")
+	lines, _ := generateLargeDiffLines(10000)
+	joined := strings.Join(lines, "\n")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		highlighter.HighlightLine(joined)
+	}
+}
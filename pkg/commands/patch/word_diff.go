@@ -0,0 +1,171 @@
+package patch
+
+// minWordDiffSimilarityRatio is the minimum Levenshtein similarity ratio two
+// paired lines must have before we attempt intra-line word diffing on them.
+// Lines that differ too much (e.g. a near-total rewrite) don't benefit from
+// word-level highlighting and are left with the normal diff background.
+const minWordDiffSimilarityRatio = 0.4
+
+// tokenSpan marks a half-open byte range [Start, End) within a line's code
+// content (i.e. after the leading +/-/space diff marker) that changed
+// relative to its paired line, and should be rendered with the brighter
+// word-diff background.
+type tokenSpan struct {
+	Start, End int
+}
+
+// computeWordDiffSpans computes the intra-line "changed" spans for a paired
+// deletion/addition line, by tokenising both with highlighter's lexer and
+// diffing the resulting token sequences. It returns nil, nil when the lexer
+// isn't available or the two lines are too dissimilar to bother pairing.
+func computeWordDiffSpans(highlighter *SyntaxHighlighter, delCode, addCode string) (delSpans, addSpans []tokenSpan) {
+	if highlighter == nil || highlighter.lexer == nil {
+		return nil, nil
+	}
+	if levenshteinRatio(delCode, addCode) < minWordDiffSimilarityRatio {
+		return nil, nil
+	}
+
+	delTokens, err := highlighter.tokeniseWithOffsets(delCode)
+	if err != nil {
+		return nil, nil
+	}
+	addTokens, err := highlighter.tokeniseWithOffsets(addCode)
+	if err != nil {
+		return nil, nil
+	}
+
+	delCommon, addCommon := lcsTokenMask(delTokens, addTokens)
+
+	return spansFromMask(delTokens, delCommon), spansFromMask(addTokens, addCommon)
+}
+
+// lcsTokenMask finds the longest common subsequence of token values between
+// a and b (an LCS-based diff, equivalent in outcome to Myers' diff for the
+// short token sequences found on a single line) and returns, for each side,
+// whether that token took part in the common subsequence (true) or changed
+// (false).
+func lcsTokenMask(a, b []diffToken) (aCommon, bCommon []bool) {
+	n, m := len(a), len(b)
+
+	dp := make([][]int, n+1)
+	for i := range dp {
+		dp[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i].value == b[j].value {
+				dp[i][j] = dp[i+1][j+1] + 1
+			} else if dp[i+1][j] >= dp[i][j+1] {
+				dp[i][j] = dp[i+1][j]
+			} else {
+				dp[i][j] = dp[i][j+1]
+			}
+		}
+	}
+
+	aCommon = make([]bool, n)
+	bCommon = make([]bool, m)
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i].value == b[j].value:
+			aCommon[i] = true
+			bCommon[j] = true
+			i++
+			j++
+		case dp[i+1][j] >= dp[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+
+	return aCommon, bCommon
+}
+
+// spansFromMask collapses the "changed" (not-common) tokens in tokens into
+// merged byte-range spans, joining adjacent changed tokens into a single
+// span so the brighter background doesn't flicker token-by-token.
+func spansFromMask(tokens []diffToken, common []bool) []tokenSpan {
+	var spans []tokenSpan
+	for i, tok := range tokens {
+		if common[i] {
+			continue
+		}
+		if len(spans) > 0 && spans[len(spans)-1].End == tok.start {
+			spans[len(spans)-1].End = tok.end
+		} else {
+			spans = append(spans, tokenSpan{Start: tok.start, End: tok.end})
+		}
+	}
+	return spans
+}
+
+// spansOverlap returns true if the half-open range [start, end) overlaps any
+// span in spans.
+func spansOverlap(spans []tokenSpan, start, end int) bool {
+	for _, span := range spans {
+		if start < span.End && end > span.Start {
+			return true
+		}
+	}
+	return false
+}
+
+// levenshteinRatio returns a similarity ratio in [0, 1] between two strings
+// based on Levenshtein edit distance: 1 means identical, 0 means completely
+// different.
+func levenshteinRatio(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+
+	maxLen := len(a)
+	if len(b) > maxLen {
+		maxLen = len(b)
+	}
+	if maxLen == 0 {
+		return 1
+	}
+
+	return 1 - float64(levenshteinDistance(a, b))/float64(maxLen)
+}
+
+// levenshteinDistance computes the classic edit distance between two
+// strings using a two-row dynamic programming table.
+func levenshteinDistance(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	n, m := len(ar), len(br)
+
+	prev := make([]int, m+1)
+	curr := make([]int, m+1)
+	for j := 0; j <= m; j++ {
+		prev[j] = j
+	}
+
+	for i := 1; i <= n; i++ {
+		curr[0] = i
+		for j := 1; j <= m; j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			curr[j] = min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[m]
+}
+
+func min3(a, b, c int) int {
+	m := a
+	if b < m {
+		m = b
+	}
+	if c < m {
+		m = c
+	}
+	return m
+}
@@ -0,0 +1,148 @@
+package patch
+
+import (
+	"math"
+	"testing"
+)
+
+func TestComputeWordDiffSpans_PairedLine(t *testing.T) {
+	highlighter := NewSyntaxHighlighter("main.go")
+
+	delSpans, addSpans := computeWordDiffSpans(highlighter, `fmt.Println("hello")`, `fmt.Println("world")`)
+
+	if len(delSpans) == 0 {
+		t.Fatalf("expected a changed span on the deletion side, got none")
+	}
+	if len(addSpans) == 0 {
+		t.Fatalf("expected a changed span on the addition side, got none")
+	}
+
+	delChanged := `fmt.Println("hello")`[delSpans[0].Start:delSpans[0].End]
+	if delChanged != `"hello"` {
+		t.Errorf("expected deletion span to cover %q, got %q", `"hello"`, delChanged)
+	}
+
+	addChanged := `fmt.Println("world")`[addSpans[0].Start:addSpans[0].End]
+	if addChanged != `"world"` {
+		t.Errorf("expected addition span to cover %q, got %q", `"world"`, addChanged)
+	}
+}
+
+func TestComputeWordDiffSpans_UnpairedLine(t *testing.T) {
+	highlighter := NewSyntaxHighlighter("main.go")
+
+	// These two lines share almost nothing, so they fall below the
+	// similarity threshold and shouldn't be paired for word diffing.
+	delSpans, addSpans := computeWordDiffSpans(highlighter, `a := 1`, `func totallyDifferentLogic() { return someLongExpression(x, y, z) }`)
+
+	if delSpans != nil || addSpans != nil {
+		t.Errorf("expected no spans for dissimilar lines, got del=%v add=%v", delSpans, addSpans)
+	}
+}
+
+func TestComputeWordDiffSpans_IdenticalLines(t *testing.T) {
+	highlighter := NewSyntaxHighlighter("main.go")
+
+	delSpans, addSpans := computeWordDiffSpans(highlighter, `return nil`, `return nil`)
+
+	if len(delSpans) != 0 || len(addSpans) != 0 {
+		t.Errorf("expected no changed spans for identical lines, got del=%v add=%v", delSpans, addSpans)
+	}
+}
+
+func TestTokeniseWithOffsets_SkipsZeroLengthSpans(t *testing.T) {
+	highlighter := NewSyntaxHighlighter("main.go")
+
+	tokens, err := highlighter.tokeniseWithOffsets("\n\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, tok := range tokens {
+		if tok.value == "" {
+			t.Errorf("expected zero-length tokens to be filtered out, found one at [%d,%d)", tok.start, tok.end)
+		}
+		if tok.start == tok.end {
+			t.Errorf("expected only non-empty spans, found zero-length span at %d", tok.start)
+		}
+	}
+}
+
+func TestHighlightLineWithWordDiff_ProducesPlainANSIString(t *testing.T) {
+	highlighter := NewSyntaxHighlighter("main.go")
+
+	out := highlighter.HighlightLineWithWordDiff(`fmt.Println("hello")`, DeletionBackground, []tokenSpan{{Start: 12, End: 19}})
+	if out == "" {
+		t.Fatalf("expected non-empty highlighted output")
+	}
+}
+
+func TestWordDiffSpansForHunk_PairedEqualLengthRun(t *testing.T) {
+	highlighter := NewSyntaxHighlighter("main.go")
+
+	bodyLines := []*PatchLine{
+		{Kind: CONTEXT, Content: ` package main`},
+		{Kind: DELETION, Content: `-fmt.Println("hello")`},
+		{Kind: ADDITION, Content: `+fmt.Println("world")`},
+		{Kind: CONTEXT, Content: ` }`},
+	}
+
+	spans := wordDiffSpansForHunk(highlighter, bodyLines)
+
+	if len(spans[1]) == 0 {
+		t.Errorf("expected a word-diff span on the deletion line (index 1)")
+	}
+	if len(spans[2]) == 0 {
+		t.Errorf("expected a word-diff span on the addition line (index 2)")
+	}
+	if _, ok := spans[0]; ok {
+		t.Errorf("expected no word-diff span on a context line")
+	}
+}
+
+func TestWordDiffSpansForHunk_UnequalRunLengthsAreNotPaired(t *testing.T) {
+	highlighter := NewSyntaxHighlighter("main.go")
+
+	bodyLines := []*PatchLine{
+		{Kind: DELETION, Content: `-a := 1`},
+		{Kind: DELETION, Content: `-b := 2`},
+		{Kind: ADDITION, Content: `+a := 1`},
+	}
+
+	spans := wordDiffSpansForHunk(highlighter, bodyLines)
+
+	if len(spans) != 0 {
+		t.Errorf("expected no word-diff spans when deletion/addition run lengths differ, got %v", spans)
+	}
+}
+
+func TestWordDiffSpansForHunk_NilHighlighterReturnsNoSpans(t *testing.T) {
+	bodyLines := []*PatchLine{
+		{Kind: DELETION, Content: `-a := 1`},
+		{Kind: ADDITION, Content: `+a := 2`},
+	}
+
+	spans := wordDiffSpansForHunk(nil, bodyLines)
+
+	if len(spans) != 0 {
+		t.Errorf("expected no word-diff spans with a nil highlighter, got %v", spans)
+	}
+}
+
+func TestLevenshteinRatio(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"abc", "abc", 1},
+		{"", "", 1},
+		{"abc", "abd", 2.0 / 3.0},
+	}
+
+	for _, tt := range tests {
+		got := levenshteinRatio(tt.a, tt.b)
+		if math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("levenshteinRatio(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}